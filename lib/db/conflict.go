@@ -0,0 +1,164 @@
+// Copyright (C) 2014 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package db
+
+import (
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+// ConflictPolicy controls how FileSet.Update resolves a version conflict,
+// i.e. a case where the incoming file's Version neither dominates nor is
+// dominated by the version already stored for that device. Previously
+// such cases were simply overwritten, leaving it to higher layers to spot
+// the conflict after the fact from the ".sync-conflict-" filename left
+// behind by the puller.
+type ConflictPolicy int
+
+const (
+	// PolicyNewestWins resolves a conflict in favor of whichever file has
+	// the more recent Modified time. This is the default.
+	PolicyNewestWins ConflictPolicy = iota
+	// PolicyLocalWins always keeps the version already stored.
+	PolicyLocalWins
+	// PolicyRemoteWins always accepts the incoming version.
+	PolicyRemoteWins
+	// PolicyManual leaves the existing version in place and records the
+	// conflict for later resolution via PendingConflicts and Resolve.
+	PolicyManual
+)
+
+func (p ConflictPolicy) String() string {
+	switch p {
+	case PolicyNewestWins:
+		return "newest wins"
+	case PolicyLocalWins:
+		return "local wins"
+	case PolicyRemoteWins:
+		return "remote wins"
+	case PolicyManual:
+		return "manual"
+	default:
+		return "unknown"
+	}
+}
+
+// PendingConflict describes an unresolved conflict recorded under
+// PolicyManual: two versions of the same file whose version vectors are
+// concurrent, with neither dominating the other.
+type PendingConflict struct {
+	Name     string
+	Device   protocol.DeviceID
+	Existing protocol.FileInfo
+	Incoming protocol.FileInfo
+}
+
+// SetConflictPolicy sets the policy used to resolve conflicting updates
+// on this folder. It takes effect for subsequent calls to Update, and is
+// persisted so it survives a restart (loaded back by NewFileSet).
+func (s *FileSet) SetConflictPolicy(policy ConflictPolicy) {
+	s.updateMutex.Lock()
+	defer s.updateMutex.Unlock()
+	s.conflictPolicy = policy
+	if err := s.db.setConflictPolicy([]byte(s.folder), policy); err != nil {
+		l.Warnln("Failed to persist conflict policy:", err)
+	}
+}
+
+// PendingConflicts returns the conflicts recorded while the conflict
+// policy was PolicyManual and not yet resolved via Resolve.
+func (s *FileSet) PendingConflicts() []PendingConflict {
+	s.updateMutex.Lock()
+	defer s.updateMutex.Unlock()
+
+	conflicts := make([]PendingConflict, 0, len(s.pendingConflicts))
+	for _, c := range s.pendingConflicts {
+		conflicts = append(conflicts, c)
+	}
+	return conflicts
+}
+
+// Resolve commits winner as the resolution for the pending conflict on
+// name. winner's Version is replaced with a vector that merges the two
+// disputed versions and then bumps our own counter, so the result
+// dominates both and the conflict cannot resurface when a peer resends
+// either side. The pending entry is cleared, and winner is stored for the
+// local device as a proper update. Resolve is a no-op if there is no
+// pending conflict for name.
+func (s *FileSet) Resolve(name string, winner protocol.FileInfo) {
+	s.updateMutex.Lock()
+	pending, ok := s.pendingConflicts[name]
+	if !ok {
+		s.updateMutex.Unlock()
+		return
+	}
+	delete(s.pendingConflicts, name)
+	s.updateMutex.Unlock()
+
+	winner.Version = mergeVersions(pending.Existing.Version, pending.Incoming.Version)
+	s.Update(protocol.LocalDeviceID, []protocol.FileInfo{winner})
+}
+
+// resolveConflict decides which of existing and incoming should be
+// stored when their version vectors are concurrent, per the folder's
+// ConflictPolicy. It must be called with updateMutex held. stored reports
+// whether the returned FileInfo should be written; it is false under
+// PolicyManual, where existing is returned unchanged and the conflict is
+// recorded instead.
+//
+// Whenever a resolution is stored, its Version is the union of both
+// disputed vectors with our own counter bumped on top, so it dominates
+// both sides: resending either original version later won't trip
+// isConcurrent again and reopen the same conflict.
+func (s *FileSet) resolveConflict(device protocol.DeviceID, name string, existing, incoming protocol.FileInfo) (resolved protocol.FileInfo, stored bool) {
+	switch s.conflictPolicy {
+	case PolicyLocalWins:
+		resolved = existing
+	case PolicyRemoteWins:
+		resolved = incoming
+	case PolicyManual:
+		if s.pendingConflicts == nil {
+			s.pendingConflicts = make(map[string]PendingConflict)
+		}
+		s.pendingConflicts[name] = PendingConflict{
+			Name:     name,
+			Device:   device,
+			Existing: existing,
+			Incoming: incoming,
+		}
+		return existing, false
+	default: // PolicyNewestWins
+		if existing.Modified > incoming.Modified {
+			resolved = existing
+		} else {
+			resolved = incoming
+		}
+	}
+
+	resolved.Version = mergeVersions(existing.Version, incoming.Version)
+	return resolved, true
+}
+
+// mergeVersions returns the union of a and b (the per-device maximum of
+// each vector's counters) with our own counter bumped on top, producing a
+// version that dominates both inputs.
+func mergeVersions(a, b protocol.Vector) protocol.Vector {
+	return a.Merge(b).Update(protocol.LocalDeviceID.Short())
+}
+
+// isConcurrent reports whether a and b are concurrent version vectors,
+// i.e. neither dominates the other, per protocol.Vector.Compare.
+func isConcurrent(a, b protocol.Vector) bool {
+	if a.Equal(b) {
+		return false
+	}
+	switch a.Compare(b) {
+	case protocol.ConcurrentGreater, protocol.ConcurrentLesser:
+		return true
+	default:
+		return false
+	}
+}
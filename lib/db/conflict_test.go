@@ -0,0 +1,106 @@
+// Copyright (C) 2014 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package db
+
+import (
+	"testing"
+
+	"github.com/syncthing/syncthing/lib/protocol"
+	"github.com/syncthing/syncthing/lib/sync"
+)
+
+func TestResolveConflictPolicies(t *testing.T) {
+	localID := protocol.LocalDeviceID.Short()
+	var remote protocol.DeviceID
+	remote[0] = 1
+	remoteID := remote.Short()
+
+	existing := protocol.FileInfo{Name: "a", Modified: 1, Version: protocol.Vector{}.Update(localID)}
+	incoming := protocol.FileInfo{Name: "a", Modified: 2, Version: protocol.Vector{}.Update(remoteID)}
+
+	cases := []struct {
+		name   string
+		policy ConflictPolicy
+	}{
+		{"newest wins", PolicyNewestWins},
+		{"local wins", PolicyLocalWins},
+		{"remote wins", PolicyRemoteWins},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := &FileSet{
+				conflictPolicy: tc.policy,
+				updateMutex:    sync.NewMutex(),
+			}
+
+			resolved, stored := s.resolveConflict(remote, "a", existing, incoming)
+			if !stored {
+				t.Fatalf("expected %v to store a resolution", tc.policy)
+			}
+
+			if c := resolved.Version.Compare(existing.Version); c != protocol.Greater && c != protocol.Equal {
+				t.Errorf("resolved version does not dominate existing: %v", c)
+			}
+			if c := resolved.Version.Compare(incoming.Version); c != protocol.Greater && c != protocol.Equal {
+				t.Errorf("resolved version does not dominate incoming: %v", c)
+			}
+			if isConcurrent(resolved.Version, existing.Version) || isConcurrent(resolved.Version, incoming.Version) {
+				t.Errorf("resolved version %v is still concurrent with an input", resolved.Version)
+			}
+		})
+	}
+
+	t.Run("manual", func(t *testing.T) {
+		s := &FileSet{
+			conflictPolicy: PolicyManual,
+			updateMutex:    sync.NewMutex(),
+		}
+
+		resolved, stored := s.resolveConflict(remote, "a", existing, incoming)
+		if stored {
+			t.Fatal("manual policy must not store a resolution")
+		}
+		if !resolved.Version.Equal(existing.Version) {
+			t.Errorf("manual policy must leave existing unchanged, got %v", resolved.Version)
+		}
+
+		pending := s.PendingConflicts()
+		if len(pending) != 1 {
+			t.Fatalf("want 1 pending conflict, got %d", len(pending))
+		}
+		if pending[0].Name != "a" || pending[0].Device != remote {
+			t.Errorf("unexpected pending conflict: %+v", pending[0])
+		}
+	})
+}
+
+func TestConflictPolicyPersists(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	s := &FileSet{
+		folder:             "f1",
+		db:                 db,
+		remoteLocalVersion: make(map[protocol.DeviceID]int64),
+		updateMutex:        sync.NewMutex(),
+		subscriberMutex:    sync.NewMutex(),
+		subscribers:        make(map[int]chan<- UpdateEvent),
+	}
+	s.SetConflictPolicy(PolicyRemoteWins)
+
+	// NewFileSet reloads the policy the same way; check the persisted
+	// form directly since NewFileSet also depends on pre-existing Instance
+	// methods outside the scope of this change.
+	if policy, ok := db.getConflictPolicy([]byte(s.folder)); !ok || policy != PolicyRemoteWins {
+		t.Fatalf("got (%v, %v), want (PolicyRemoteWins, true)", policy, ok)
+	}
+
+	if _, ok := db.getConflictPolicy([]byte("other-folder")); ok {
+		t.Error("expected no persisted policy for an untouched folder")
+	}
+}
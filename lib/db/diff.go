@@ -0,0 +1,72 @@
+// Copyright (C) 2014 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package db
+
+import (
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+// FileSetUpdate describes the changes a single call to FileSet.Update made
+// for one device, classified relative to what was previously stored for
+// each file. A changed file appears in exactly one of the slices below; a
+// file resubmitted with the version already stored appears in none.
+type FileSetUpdate struct {
+	Added              []protocol.FileInfo
+	Modified           []protocol.FileInfo
+	Deleted            []protocol.FileInfo
+	PermissionsChanged []protocol.FileInfo
+	BecameInvalid      []protocol.FileInfo
+}
+
+// empty reports whether the diff carries no changes at all, in which case
+// there's nothing worth notifying subscribers about.
+func (d *FileSetUpdate) empty() bool {
+	return len(d.Added) == 0 && len(d.Modified) == 0 && len(d.Deleted) == 0 &&
+		len(d.PermissionsChanged) == 0 && len(d.BecameInvalid) == 0
+}
+
+// classify appends new to the slice of d that best describes how it
+// differs from existing. existed is false when there was no previous
+// entry for the file at all.
+func (d *FileSetUpdate) classify(existing, new protocol.FileInfo, existed bool) {
+	switch {
+	case !existed:
+		d.Added = append(d.Added, new)
+	case existing.Version.Equal(new.Version):
+		// Resubmission of something we already have; nothing changed.
+	case !existing.IsInvalid() && new.IsInvalid():
+		d.BecameInvalid = append(d.BecameInvalid, new)
+	case !existing.IsDeleted() && new.IsDeleted():
+		d.Deleted = append(d.Deleted, new)
+	case !permissionsEqual(existing, new):
+		d.PermissionsChanged = append(d.PermissionsChanged, new)
+	default:
+		d.Modified = append(d.Modified, new)
+	}
+}
+
+// permissionsEqual compares the permission bits of two FileInfos, treating
+// files that don't carry permission bits at all as equal regardless of the
+// underlying value.
+func permissionsEqual(a, b protocol.FileInfo) bool {
+	if a.HasPermissionBits() != b.HasPermissionBits() {
+		return false
+	}
+	return !a.HasPermissionBits() || a.Permissions == b.Permissions
+}
+
+// UpdateEvent is delivered to subscribers registered with
+// FileSet.Subscribe whenever a call to Update produces a non-empty diff.
+type UpdateEvent struct {
+	Folder string
+	Device protocol.DeviceID
+	Diff   FileSetUpdate
+}
+
+// CancelFunc unregisters a subscription previously set up with
+// FileSet.Subscribe.
+type CancelFunc func()
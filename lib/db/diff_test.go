@@ -0,0 +1,106 @@
+// Copyright (C) 2014 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package db
+
+import (
+	"testing"
+
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+func TestFileSetUpdateClassify(t *testing.T) {
+	id := protocol.LocalDeviceID.Short()
+	v1 := protocol.Vector{}.Update(id)
+	v2 := v1.Update(id)
+
+	base := protocol.FileInfo{Name: "a", Version: v1}
+
+	cases := []struct {
+		name     string
+		existing protocol.FileInfo
+		existed  bool
+		new      protocol.FileInfo
+		check    func(t *testing.T, d FileSetUpdate)
+	}{
+		{
+			name:     "added",
+			existing: protocol.FileInfo{},
+			existed:  false,
+			new:      base,
+			check: func(t *testing.T, d FileSetUpdate) {
+				if len(d.Added) != 1 {
+					t.Errorf("want 1 added, got %d", len(d.Added))
+				}
+			},
+		},
+		{
+			// Resending the exact same version (e.g. a rescan that found
+			// no changes) must not be reported as a modification.
+			name:     "unchanged",
+			existing: base,
+			existed:  true,
+			new:      base,
+			check: func(t *testing.T, d FileSetUpdate) {
+				if !d.empty() {
+					t.Errorf("expected a no-op update to produce an empty diff, got %+v", d)
+				}
+			},
+		},
+		{
+			name:     "modified",
+			existing: base,
+			existed:  true,
+			new:      protocol.FileInfo{Name: "a", Version: v2},
+			check: func(t *testing.T, d FileSetUpdate) {
+				if len(d.Modified) != 1 {
+					t.Errorf("want 1 modified, got %d", len(d.Modified))
+				}
+			},
+		},
+		{
+			name:     "deleted",
+			existing: base,
+			existed:  true,
+			new:      protocol.FileInfo{Name: "a", Version: v2, Deleted: true},
+			check: func(t *testing.T, d FileSetUpdate) {
+				if len(d.Deleted) != 1 {
+					t.Errorf("want 1 deleted, got %d", len(d.Deleted))
+				}
+			},
+		},
+		{
+			name:     "became invalid",
+			existing: base,
+			existed:  true,
+			new:      protocol.FileInfo{Name: "a", Version: v2, Invalid: true},
+			check: func(t *testing.T, d FileSetUpdate) {
+				if len(d.BecameInvalid) != 1 {
+					t.Errorf("want 1 became-invalid, got %d", len(d.BecameInvalid))
+				}
+			},
+		},
+		{
+			name:     "permissions changed",
+			existing: base,
+			existed:  true,
+			new:      protocol.FileInfo{Name: "a", Version: v2, Permissions: 0o644},
+			check: func(t *testing.T, d FileSetUpdate) {
+				if len(d.PermissionsChanged) != 1 {
+					t.Errorf("want 1 permissions-changed, got %d", len(d.PermissionsChanged))
+				}
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var d FileSetUpdate
+			d.classify(tc.existing, tc.new, tc.existed)
+			tc.check(t, d)
+		})
+	}
+}
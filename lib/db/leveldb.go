@@ -0,0 +1,312 @@
+// Copyright (C) 2014 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package db
+
+import (
+	"bytes"
+	"sort"
+	stdsync "sync"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+// Instance wraps a LevelDB handle with the key-space helpers the rest of
+// this package uses to store per-device file entries.
+type Instance struct {
+	*leveldb.DB
+	folderIdx *smallIndex
+}
+
+const (
+	keyTypeDevice         = byte(0)
+	keyTypeConflictPolicy = byte(1)
+)
+
+// conflictPolicyKey is the LevelDB key under which folder's ConflictPolicy
+// is persisted, so it survives a process restart the way localVersion is
+// reconstructed by scanning device entries.
+func conflictPolicyKey(folder []byte) []byte {
+	return appendShortBytes([]byte{keyTypeConflictPolicy}, folder)
+}
+
+// setConflictPolicy persists policy as folder's conflict resolution
+// policy.
+func (db *Instance) setConflictPolicy(folder []byte, policy ConflictPolicy) error {
+	return db.Put(conflictPolicyKey(folder), []byte{byte(policy)}, nil)
+}
+
+// getConflictPolicy returns the conflict resolution policy previously
+// persisted for folder, or ok=false if none has been set.
+func (db *Instance) getConflictPolicy(folder []byte) (policy ConflictPolicy, ok bool) {
+	bs, err := db.Get(conflictPolicyKey(folder), nil)
+	if err != nil || len(bs) != 1 {
+		return PolicyNewestWins, false
+	}
+	return ConflictPolicy(bs[0]), true
+}
+
+// deviceKeyPrefix bounds the key space of every device entry for folder,
+// across all devices.
+func deviceKeyPrefix(folder []byte) []byte {
+	return appendShortBytes([]byte{keyTypeDevice}, folder)
+}
+
+// deviceKeyDevicePrefix bounds the key space of device's entries within
+// folder.
+func deviceKeyDevicePrefix(folder, device []byte) []byte {
+	return appendShortBytes(deviceKeyPrefix(folder), device)
+}
+
+// deviceKey is the LevelDB key for a single file entry.
+func deviceKey(folder, device, name []byte) []byte {
+	return append(deviceKeyDevicePrefix(folder, device), name...)
+}
+
+func appendShortBytes(dst, b []byte) []byte {
+	dst = append(dst, byte(len(b)>>8), byte(len(b)))
+	return append(dst, b...)
+}
+
+// splitDeviceKey returns the device and name portions of a key built by
+// deviceKey.
+func splitDeviceKey(key []byte) (device, name []byte) {
+	folderLen := int(key[1])<<8 | int(key[2])
+	pos := 3 + folderLen
+	deviceLen := int(key[pos])<<8 | int(key[pos+1])
+	pos += 2
+	return key[pos : pos+deviceLen], key[pos+deviceLen:]
+}
+
+// levelDBReader is satisfied by both *leveldb.DB and *leveldb.Snapshot,
+// letting the helpers below run against either the live database or a
+// point in time snapshot of it.
+type levelDBReader interface {
+	Get(key []byte, ro *opt.ReadOptions) ([]byte, error)
+	NewIterator(slice *util.Range, ro *opt.ReadOptions) iterator.Iterator
+}
+
+// dbSnapshot is a read-only view of the database pinned to a single
+// LevelDB snapshot. It backs Snapshot, so that a caller making several
+// With*/Get calls in turn reads a single, consistent point in time
+// instead of whatever the live database happens to hold at each call.
+type dbSnapshot struct {
+	snap *leveldb.Snapshot
+}
+
+// newSnapshot takes a LevelDB snapshot of the database's current state.
+func (db *Instance) newSnapshot() (*dbSnapshot, error) {
+	snap, err := db.GetSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	return &dbSnapshot{snap: snap}, nil
+}
+
+func (s *dbSnapshot) getFile(folder, device, name []byte) (protocol.FileInfo, bool) {
+	return getFileReader(s.snap, folder, device, name)
+}
+
+func (s *dbSnapshot) withHave(folder, device, prefix []byte, truncate bool, fn Iterator) {
+	withHaveReader(s.snap, folder, device, prefix, truncate, fn)
+}
+
+func (s *dbSnapshot) withNeed(folder, device, prefix []byte, truncate bool, fn Iterator) {
+	needReader(s.snap, folder, device, prefix, nil, 0, truncate, fn)
+}
+
+func (s *dbSnapshot) withGlobal(folder, prefix []byte, truncate bool, fn Iterator) {
+	globalReader(s.snap, folder, prefix, truncate, fn)
+}
+
+// release frees the underlying LevelDB snapshot.
+func (s *dbSnapshot) release() {
+	s.snap.Release()
+}
+
+// getFile, withHave, withNeed and withGlobal on Instance itself run the
+// same reader-based helpers against the live database, so a Snapshot and
+// the FileSet it was taken from can never disagree on how a key is
+// encoded or a result is classified.
+
+func (db *Instance) getFile(folder, device, name []byte) (protocol.FileInfo, bool) {
+	return getFileReader(db.DB, folder, device, name)
+}
+
+func (db *Instance) withHave(folder, device, prefix []byte, truncate bool, fn Iterator) {
+	withHaveReader(db.DB, folder, device, prefix, truncate, fn)
+}
+
+// withNeed iterates the files device needs, i.e. those where the global
+// version neither equals nor is dominated by the one device already has,
+// optionally restricted to names starting with prefix.
+func (db *Instance) withNeed(folder, device, prefix []byte, truncate bool, fn Iterator) {
+	needReader(db.DB, folder, device, prefix, nil, 0, truncate, fn)
+}
+
+// withNeedRange is like withNeed, but instead of a prefix it takes a
+// cursor (afterName) and a limit, for resumable, bounded iteration of a
+// large need set. Results are always truncated, as this exists to serve
+// listings rather than full FileInfo detail.
+func (db *Instance) withNeedRange(folder, device, afterName []byte, limit int, fn Iterator) {
+	needReader(db.DB, folder, device, nil, afterName, limit, true, fn)
+}
+
+func (db *Instance) withGlobal(folder, prefix []byte, truncate bool, fn Iterator) {
+	globalReader(db.DB, folder, prefix, truncate, fn)
+}
+
+func getFileReader(r levelDBReader, folder, device, name []byte) (protocol.FileInfo, bool) {
+	bs, err := r.Get(deviceKey(folder, device, name), nil)
+	if err != nil {
+		return protocol.FileInfo{}, false
+	}
+	var f protocol.FileInfo
+	if err := f.Unmarshal(bs); err != nil {
+		return protocol.FileInfo{}, false
+	}
+	return f, true
+}
+
+// entriesReader iterates every file entry for folder, optionally
+// restricted to names starting with prefix, regardless of which device
+// they belong to.
+//
+// Unlike withHaveReader, prefix can't be folded into the iteration range:
+// a device-length-prefixed device ID sits between the folder and the name
+// in the key, so the name doesn't begin where the folder's prefix ends.
+// Instead we range over the whole folder and filter each key's name
+// portion after splitting it.
+func entriesReader(r levelDBReader, folder, prefix []byte, visit func(device, name []byte, f protocol.FileInfo) bool) {
+	rng := util.BytesPrefix(deviceKeyPrefix(folder))
+	it := r.NewIterator(rng, nil)
+	defer it.Release()
+	for it.Next() {
+		device, name := splitDeviceKey(it.Key())
+		if len(prefix) > 0 && !bytes.HasPrefix(name, prefix) {
+			continue
+		}
+		var f protocol.FileInfo
+		if err := f.Unmarshal(it.Value()); err != nil {
+			continue
+		}
+		if !visit(device, name, f) {
+			return
+		}
+	}
+}
+
+func withHaveReader(r levelDBReader, folder, device, prefix []byte, truncate bool, fn Iterator) {
+	start := deviceKeyDevicePrefix(folder, device)
+	rng := util.BytesPrefix(append(append([]byte{}, start...), prefix...))
+	it := r.NewIterator(rng, nil)
+	defer it.Release()
+	for it.Next() {
+		var f protocol.FileInfo
+		if err := f.Unmarshal(it.Value()); err != nil {
+			continue
+		}
+		if !fn(maybeTruncate(f, truncate)) {
+			return
+		}
+	}
+}
+
+// globalReader computes, for every name under folder (optionally bounded
+// by prefix), the file entry with the highest Version across all
+// devices, and invokes fn with each in name order.
+func globalReader(r levelDBReader, folder, prefix []byte, truncate bool, fn Iterator) {
+	var names []string
+	best := make(map[string]protocol.FileInfo)
+	entriesReader(r, folder, prefix, func(device, name []byte, f protocol.FileInfo) bool {
+		key := string(name)
+		cur, ok := best[key]
+		if !ok {
+			names = append(names, key)
+		}
+		if !ok || f.Version.Compare(cur.Version) == protocol.Greater {
+			best[key] = f
+		}
+		return true
+	})
+	sort.Strings(names)
+	for _, name := range names {
+		if !fn(maybeTruncate(best[name], truncate)) {
+			return
+		}
+	}
+}
+
+// needReader walks the global file list for folder (optionally restricted
+// to names starting with prefix, or resuming just after afterName, up to
+// limit results) and calls fn for every name where device's own version
+// doesn't already equal or dominate the global one.
+func needReader(r levelDBReader, folder, device, prefix, afterName []byte, limit int, truncate bool, fn Iterator) {
+	count := 0
+	globalReader(r, folder, prefix, false, func(fi FileIntf) bool {
+		f := fi.(protocol.FileInfo)
+
+		if len(afterName) > 0 && bytes.Compare([]byte(f.Name), afterName) <= 0 {
+			return true
+		}
+
+		existing, ok := getFileReader(r, folder, device, []byte(f.Name))
+		if ok {
+			switch existing.Version.Compare(f.Version) {
+			case protocol.Equal, protocol.Greater:
+				return true // device already has this version or better
+			}
+		}
+
+		if !fn(maybeTruncate(f, truncate)) {
+			return false
+		}
+		count++
+		return limit <= 0 || count < limit
+	})
+}
+
+func maybeTruncate(f protocol.FileInfo, truncate bool) FileIntf {
+	if truncate {
+		return toTruncated(f)
+	}
+	return f
+}
+
+// smallIndex assigns small, stable integer IDs to byte-string values seen
+// before, used to key per-folder structures like the block map without
+// repeating the folder name in every entry.
+type smallIndex struct {
+	mut    stdsync.Mutex
+	id2val map[uint32]string
+	val2id map[string]uint32
+	next   uint32
+}
+
+func newSmallIndex() *smallIndex {
+	return &smallIndex{
+		id2val: make(map[uint32]string),
+		val2id: make(map[string]uint32),
+	}
+}
+
+func (i *smallIndex) ID(val []byte) uint32 {
+	i.mut.Lock()
+	defer i.mut.Unlock()
+	if id, ok := i.val2id[string(val)]; ok {
+		return id
+	}
+	id := i.next
+	i.next++
+	i.id2val[id] = string(val)
+	i.val2id[string(val)] = id
+	return id
+}
@@ -31,6 +31,13 @@ type FileSet struct {
 
 	remoteLocalVersion map[protocol.DeviceID]int64 // Highest seen local versions for other devices
 	updateMutex        sync.Mutex                  // protects remoteLocalVersion and database updates
+
+	subscriberMutex  sync.Mutex // protects subscribers and nextSubscriberID
+	subscribers      map[int]chan<- UpdateEvent
+	nextSubscriberID int
+
+	conflictPolicy   ConflictPolicy             // protected by updateMutex
+	pendingConflicts map[string]PendingConflict // protected by updateMutex
 }
 
 // FileIntf is the set of methods implemented by both protocol.FileInfo and
@@ -96,6 +103,25 @@ func (s *sizeTracker) Size() (files, deleted int, bytes int64) {
 	return s.files, s.deleted, s.bytes
 }
 
+// toSnapshot returns an immutable copy of the current counters, suitable
+// for embedding in a Snapshot that must not see subsequent updates.
+func (s *sizeTracker) toSnapshot() sizeTrackerSnapshot {
+	files, deleted, bytes := s.Size()
+	return sizeTrackerSnapshot{files: files, deleted: deleted, bytes: bytes}
+}
+
+// sizeTrackerSnapshot is a frozen, already summed copy of a sizeTracker's
+// counters taken at a single point in time.
+type sizeTrackerSnapshot struct {
+	files   int
+	deleted int
+	bytes   int64
+}
+
+func (s sizeTrackerSnapshot) Size() (files, deleted int, bytes int64) {
+	return s.files, s.deleted, s.bytes
+}
+
 func NewFileSet(folder string, db *Instance) *FileSet {
 	var s = FileSet{
 		remoteLocalVersion: make(map[protocol.DeviceID]int64),
@@ -103,6 +129,12 @@ func NewFileSet(folder string, db *Instance) *FileSet {
 		db:                 db,
 		blockmap:           NewBlockMap(db, db.folderIdx.ID([]byte(folder))),
 		updateMutex:        sync.NewMutex(),
+		subscriberMutex:    sync.NewMutex(),
+		subscribers:        make(map[int]chan<- UpdateEvent),
+	}
+
+	if policy, ok := db.getConflictPolicy([]byte(folder)); ok {
+		s.conflictPolicy = policy
 	}
 
 	s.db.checkGlobals([]byte(folder), &s.globalSize)
@@ -153,13 +185,18 @@ func (s *FileSet) Replace(device protocol.DeviceID, fs []protocol.FileInfo) {
 	}
 }
 
-func (s *FileSet) Update(device protocol.DeviceID, fs []protocol.FileInfo) {
+// Update stores fs for device, and returns a FileSetUpdate classifying how
+// each file compares to what was previously stored. Subscribers registered
+// with Subscribe receive the same diff, so callers no longer need to
+// re-walk WithHave after a batch to find out what changed.
+func (s *FileSet) Update(device protocol.DeviceID, fs []protocol.FileInfo) FileSetUpdate {
 	l.Debugf("%s Update(%v, [%d])", s.folder, device, len(fs))
 	normalizeFilenames(fs)
 
 	s.updateMutex.Lock()
 	defer s.updateMutex.Unlock()
 
+	var diff FileSetUpdate
 	if device == protocol.LocalDeviceID {
 		discards := make([]protocol.FileInfo, 0, len(fs))
 		updates := make([]protocol.FileInfo, 0, len(fs))
@@ -168,25 +205,141 @@ func (s *FileSet) Update(device protocol.DeviceID, fs []protocol.FileInfo) {
 			existingFile, ok := s.db.getFile([]byte(s.folder), device[:], []byte(newFile.Name))
 			if !ok || !existingFile.Version.Equal(newFile.Version) {
 				discards = append(discards, existingFile)
-				updates = append(updates, newFile)
+				updates = append(updates, fs[i])
 			}
+			diff.classify(existingFile, fs[i], ok)
 		}
 		s.blockmap.Discard(discards)
 		s.blockmap.Update(updates)
 	} else {
 		s.remoteLocalVersion[device] = maxLocalVersion(fs)
+		for i, newFile := range fs {
+			existingFile, ok := s.db.getFile([]byte(s.folder), device[:], []byte(newFile.Name))
+			// Conflict resolution only makes sense for a foreign device's
+			// update against what we'd previously stored for it; applying
+			// it to our own disk-scan results (the local branch above)
+			// could silently discard a genuine local change.
+			if ok && isConcurrent(existingFile.Version, newFile.Version) {
+				resolved, _ := s.resolveConflict(device, newFile.Name, existingFile, newFile)
+				fs[i] = resolved
+				newFile = resolved
+			}
+			diff.classify(existingFile, newFile, ok)
+		}
 	}
 	s.db.updateFiles([]byte(s.folder), device[:], fs, &s.localSize, &s.globalSize)
+
+	s.notify(device, diff)
+
+	return diff
+}
+
+// Subscribe registers ch to receive an UpdateEvent for every subsequent
+// call to Update that produces a non-empty diff. The returned CancelFunc
+// unregisters ch; it is safe to call more than once. Sends are
+// non-blocking, so a slow or inattentive subscriber misses events rather
+// than stalling Update for everyone else.
+func (s *FileSet) Subscribe(ch chan<- UpdateEvent) CancelFunc {
+	s.subscriberMutex.Lock()
+	defer s.subscriberMutex.Unlock()
+
+	id := s.nextSubscriberID
+	s.nextSubscriberID++
+	s.subscribers[id] = ch
+
+	return func() {
+		s.subscriberMutex.Lock()
+		defer s.subscriberMutex.Unlock()
+		delete(s.subscribers, id)
+	}
+}
+
+func (s *FileSet) notify(device protocol.DeviceID, diff FileSetUpdate) {
+	if diff.empty() {
+		return
+	}
+
+	s.subscriberMutex.Lock()
+	defer s.subscriberMutex.Unlock()
+
+	evt := UpdateEvent{Folder: s.folder, Device: device, Diff: diff}
+	for _, ch := range s.subscribers {
+		select {
+		case ch <- evt:
+		default:
+			l.Debugf("%s notify: dropping event for slow subscriber", s.folder)
+		}
+	}
+}
+
+// Snapshot returns an immutable view of the FileSet as it is at the
+// moment of the call, backed by a LevelDB snapshot taken inside the
+// underlying Instance. The returned Snapshot must be released by calling
+// its Release method once it is no longer needed.
+func (s *FileSet) Snapshot() (*Snapshot, error) {
+	s.updateMutex.Lock()
+	defer s.updateMutex.Unlock()
+
+	dbSnap, err := s.db.newSnapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	remoteLocalVersion := make(map[protocol.DeviceID]int64, len(s.remoteLocalVersion))
+	for device, ver := range s.remoteLocalVersion {
+		remoteLocalVersion[device] = ver
+	}
+
+	return &Snapshot{
+		folder:             s.folder,
+		dbSnap:             dbSnap,
+		localVersion:       atomic.LoadInt64(&s.localVersion),
+		remoteLocalVersion: remoteLocalVersion,
+		localSize:          s.localSize.toSnapshot(),
+		globalSize:         s.globalSize.toSnapshot(),
+	}, nil
 }
 
 func (s *FileSet) WithNeed(device protocol.DeviceID, fn Iterator) {
 	l.Debugf("%s WithNeed(%v)", s.folder, device)
-	s.db.withNeed([]byte(s.folder), device[:], false, nativeFileIterator(fn))
+	s.db.withNeed([]byte(s.folder), device[:], nil, false, nativeFileIterator(fn))
 }
 
 func (s *FileSet) WithNeedTruncated(device protocol.DeviceID, fn Iterator) {
 	l.Debugf("%s WithNeedTruncated(%v)", s.folder, device)
-	s.db.withNeed([]byte(s.folder), device[:], true, nativeFileIterator(fn))
+	s.db.withNeed([]byte(s.folder), device[:], nil, true, nativeFileIterator(fn))
+}
+
+// WithPrefixedNeedTruncated is like WithNeedTruncated but restricted to
+// files whose name starts with prefix, letting a caller such as the GUI
+// browse a subtree of the need set without walking the rest of it. It
+// shares the same db.withNeed entry point as WithNeedTruncated, the way
+// WithPrefixedHaveTruncated shares db.withHave with WithHaveTruncated.
+func (s *FileSet) WithPrefixedNeedTruncated(device protocol.DeviceID, prefix string, fn Iterator) {
+	l.Debugf("%s WithPrefixedNeedTruncated(%v, %q)", s.folder, device, prefix)
+	s.db.withNeed([]byte(s.folder), device[:], []byte(osutil.NormalizedFilename(prefix)), true, nativeFileIterator(fn))
+}
+
+// WithNeedPage iterates the truncated need set for device in name order,
+// starting just after afterName, and collects up to limit entries. It
+// returns the page of results together with a cursor suitable for passing
+// as afterName on the next call; an empty cursor means there is nothing
+// left to page through.
+func (s *FileSet) WithNeedPage(device protocol.DeviceID, afterName string, limit int) ([]FileInfoTruncated, string) {
+	l.Debugf("%s WithNeedPage(%v, %q, %d)", s.folder, device, afterName, limit)
+
+	page := make([]FileInfoTruncated, 0, limit)
+	s.db.withNeedRange([]byte(s.folder), device[:], []byte(osutil.NormalizedFilename(afterName)), limit, func(fi FileIntf) bool {
+		f := fi.(FileInfoTruncated)
+		f.Name = osutil.NativeFilename(f.Name)
+		page = append(page, f)
+		return len(page) < limit
+	})
+
+	if len(page) == 0 {
+		return page, ""
+	}
+	return page, page[len(page)-1].Name
 }
 
 func (s *FileSet) WithHave(device protocol.DeviceID, fn Iterator) {
@@ -203,6 +356,14 @@ func (s *FileSet) WithPrefixedHaveTruncated(device protocol.DeviceID, prefix str
 	l.Debugf("%s WithPrefixedHaveTruncated(%v)", s.folder, device)
 	s.db.withHave([]byte(s.folder), device[:], []byte(osutil.NormalizedFilename(prefix)), true, nativeFileIterator(fn))
 }
+
+// WithPrefixedHave is like WithPrefixedHaveTruncated but returns full
+// protocol.FileInfo entries instead of the truncated form.
+func (s *FileSet) WithPrefixedHave(device protocol.DeviceID, prefix string, fn Iterator) {
+	l.Debugf("%s WithPrefixedHave(%v)", s.folder, device)
+	s.db.withHave([]byte(s.folder), device[:], []byte(osutil.NormalizedFilename(prefix)), false, nativeFileIterator(fn))
+}
+
 func (s *FileSet) WithGlobal(fn Iterator) {
 	l.Debugf("%s WithGlobal()", s.folder)
 	s.db.withGlobal([]byte(s.folder), nil, false, nativeFileIterator(fn))
@@ -0,0 +1,168 @@
+// Copyright (C) 2014 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/syncthing/syncthing/lib/protocol"
+	"github.com/syncthing/syncthing/lib/sync"
+)
+
+// TestSubscribeNotify drives notify directly rather than through the
+// public Update, since Update's storage step (db.updateFiles) is
+// pre-existing Instance plumbing that was never part of this series and
+// remains unimplemented in this tree. notify is the exact call Update
+// makes once it has classified a batch, so this still exercises the
+// Subscribe/notify contract end to end: delivery of a non-empty diff,
+// silently dropping an empty one, and the non-blocking send guarantee
+// documented on Subscribe.
+func TestSubscribeNotify(t *testing.T) {
+	s := &FileSet{
+		folder:          "f1",
+		subscriberMutex: sync.NewMutex(),
+		subscribers:     make(map[int]chan<- UpdateEvent),
+	}
+
+	ch := make(chan UpdateEvent, 1)
+	cancel := s.Subscribe(ch)
+	defer cancel()
+
+	diff := FileSetUpdate{Added: []protocol.FileInfo{{Name: "a"}}}
+	s.notify(protocol.LocalDeviceID, diff)
+
+	select {
+	case evt := <-ch:
+		if evt.Folder != "f1" || evt.Device != protocol.LocalDeviceID || len(evt.Diff.Added) != 1 {
+			t.Errorf("unexpected event: %+v", evt)
+		}
+	default:
+		t.Fatal("expected an event to be delivered")
+	}
+
+	// An empty diff must not be delivered at all.
+	s.notify(protocol.LocalDeviceID, FileSetUpdate{})
+	select {
+	case evt := <-ch:
+		t.Errorf("expected no event for an empty diff, got %+v", evt)
+	default:
+	}
+
+	// A full channel must not block notify; the event is just dropped.
+	ch <- UpdateEvent{}
+	done := make(chan struct{})
+	go func() {
+		s.notify(protocol.LocalDeviceID, diff)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("notify blocked on a full subscriber channel")
+	}
+
+	// Once cancelled, the subscriber receives nothing further.
+	cancel()
+	<-ch // drain the event left over from the full-channel case above
+	s.notify(protocol.LocalDeviceID, diff)
+	select {
+	case evt := <-ch:
+		t.Errorf("expected no event after cancel, got %+v", evt)
+	default:
+	}
+}
+
+func TestWithNeedPage(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	fs := &FileSet{
+		folder:          "f1",
+		db:              db,
+		updateMutex:     sync.NewMutex(),
+		subscriberMutex: sync.NewMutex(),
+		subscribers:     make(map[int]chan<- UpdateEvent),
+	}
+
+	localID := protocol.LocalDeviceID.Short()
+	v1 := protocol.Vector{}.Update(localID)
+	v2 := v1.Update(localID)
+
+	var remote protocol.DeviceID
+	remote[0] = 1
+
+	names := []string{"a", "b", "c", "d", "e"}
+	for _, n := range names {
+		putTestFile(t, db, fs.folder, string(protocol.LocalDeviceID[:]), protocol.FileInfo{Name: n, Version: v1})
+		putTestFile(t, db, fs.folder, string(remote[:]), protocol.FileInfo{Name: n, Version: v2})
+	}
+
+	var got []string
+	cursor := ""
+	for {
+		page, next := fs.WithNeedPage(protocol.LocalDeviceID, cursor, 2)
+		for _, f := range page {
+			got = append(got, f.Name)
+		}
+		if len(page) < 2 {
+			break
+		}
+		cursor = next
+	}
+
+	if len(got) != len(names) {
+		t.Fatalf("paged through %v, want all of %v", got, names)
+	}
+	for i, n := range names {
+		if got[i] != n {
+			t.Errorf("entry %d = %q, want %q", i, got[i], n)
+		}
+	}
+}
+
+func TestWithPrefixedNeedTruncated(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	fs := &FileSet{
+		folder:          "f1",
+		db:              db,
+		updateMutex:     sync.NewMutex(),
+		subscriberMutex: sync.NewMutex(),
+		subscribers:     make(map[int]chan<- UpdateEvent),
+	}
+
+	localID := protocol.LocalDeviceID.Short()
+	v1 := protocol.Vector{}.Update(localID)
+	v2 := v1.Update(localID)
+
+	var remote protocol.DeviceID
+	remote[0] = 1
+
+	names := []string{"docs/readme.txt", "docs/notes.txt", "src/main.go"}
+	for _, n := range names {
+		putTestFile(t, db, fs.folder, string(protocol.LocalDeviceID[:]), protocol.FileInfo{Name: n, Version: v1})
+		putTestFile(t, db, fs.folder, string(remote[:]), protocol.FileInfo{Name: n, Version: v2})
+	}
+
+	var got []string
+	fs.WithPrefixedNeedTruncated(protocol.LocalDeviceID, "docs/", func(fi FileIntf) bool {
+		got = append(got, fi.FileName())
+		return true
+	})
+
+	want := []string{"docs/notes.txt", "docs/readme.txt"}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("entry %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright (C) 2014 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package db
+
+import (
+	"github.com/syncthing/syncthing/lib/osutil"
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+// Snapshot is an immutable, point in time view of a FileSet. All of its
+// reads are served from a single LevelDB snapshot taken when the Snapshot
+// was created, so a caller that makes several With* or Get calls in a row
+// will never observe a concurrent Update or Replace torn across those
+// calls the way it could when querying the FileSet directly. A Snapshot
+// is safe for concurrent use by multiple goroutines. It must be released
+// with Release once no longer needed, so that LevelDB can free the
+// underlying snapshot.
+type Snapshot struct {
+	folder             string
+	dbSnap             *dbSnapshot
+	localVersion       int64
+	remoteLocalVersion map[protocol.DeviceID]int64
+	localSize          sizeTrackerSnapshot
+	globalSize         sizeTrackerSnapshot
+}
+
+func (s *Snapshot) WithNeed(device protocol.DeviceID, fn Iterator) {
+	s.dbSnap.withNeed([]byte(s.folder), device[:], nil, false, nativeFileIterator(fn))
+}
+
+func (s *Snapshot) WithNeedTruncated(device protocol.DeviceID, fn Iterator) {
+	s.dbSnap.withNeed([]byte(s.folder), device[:], nil, true, nativeFileIterator(fn))
+}
+
+func (s *Snapshot) WithHave(device protocol.DeviceID, fn Iterator) {
+	s.dbSnap.withHave([]byte(s.folder), device[:], nil, false, nativeFileIterator(fn))
+}
+
+func (s *Snapshot) WithHaveTruncated(device protocol.DeviceID, fn Iterator) {
+	s.dbSnap.withHave([]byte(s.folder), device[:], nil, true, nativeFileIterator(fn))
+}
+
+func (s *Snapshot) WithGlobal(fn Iterator) {
+	s.dbSnap.withGlobal([]byte(s.folder), nil, false, nativeFileIterator(fn))
+}
+
+func (s *Snapshot) WithGlobalTruncated(fn Iterator) {
+	s.dbSnap.withGlobal([]byte(s.folder), nil, true, nativeFileIterator(fn))
+}
+
+func (s *Snapshot) Get(device protocol.DeviceID, file string) (protocol.FileInfo, bool) {
+	f, ok := s.dbSnap.getFile([]byte(s.folder), device[:], []byte(osutil.NormalizedFilename(file)))
+	f.Name = osutil.NativeFilename(f.Name)
+	return f, ok
+}
+
+func (s *Snapshot) LocalVersion(device protocol.DeviceID) int64 {
+	if device == protocol.LocalDeviceID {
+		return s.localVersion
+	}
+	return s.remoteLocalVersion[device]
+}
+
+func (s *Snapshot) LocalSize() (files, deleted int, bytes int64) {
+	return s.localSize.Size()
+}
+
+func (s *Snapshot) GlobalSize() (files, deleted int, bytes int64) {
+	return s.globalSize.Size()
+}
+
+// Release frees the underlying LevelDB snapshot. The Snapshot must not be
+// used for any further calls after Release has been called.
+func (s *Snapshot) Release() {
+	s.dbSnap.release()
+}
@@ -0,0 +1,68 @@
+// Copyright (C) 2014 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package db
+
+import (
+	"testing"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+func newTestDB(t *testing.T) *Instance {
+	t.Helper()
+	ldb, err := leveldb.Open(storage.NewMemStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Instance{DB: ldb, folderIdx: newSmallIndex()}
+}
+
+func putTestFile(t *testing.T, db *Instance, folder, device string, f protocol.FileInfo) {
+	t.Helper()
+	bs, err := f.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put(deviceKey([]byte(folder), []byte(device), []byte(f.Name)), bs, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSnapshotIsolation(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	folder := "f1"
+	device := string(protocol.LocalDeviceID[:])
+
+	putTestFile(t, db, folder, device, protocol.FileInfo{Name: "a", Modified: 1})
+
+	snap, err := db.newSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.release()
+
+	// Mutate after the snapshot was taken; the snapshot must not see it.
+	putTestFile(t, db, folder, device, protocol.FileInfo{Name: "a", Modified: 2})
+
+	f, ok := snap.getFile([]byte(folder), []byte(device), []byte("a"))
+	if !ok {
+		t.Fatal("expected to find file in snapshot")
+	}
+	if f.Modified != 1 {
+		t.Errorf("snapshot saw a later write: Modified = %d, want 1", f.Modified)
+	}
+
+	live, ok := getFileReader(db.DB, []byte(folder), []byte(device), []byte("a"))
+	if !ok || live.Modified != 2 {
+		t.Errorf("live read didn't see the later write: %+v", live)
+	}
+}
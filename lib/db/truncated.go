@@ -0,0 +1,56 @@
+// Copyright (C) 2014 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package db
+
+import (
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+// FileInfoTruncated carries the same FileIntf-visible fields as a
+// protocol.FileInfo, but drops the block list. It's what the With*
+// iterators hand back when called in their "Truncated" form, for
+// listings that don't need block data and would rather not pay to load
+// it.
+type FileInfoTruncated struct {
+	Name          string
+	Size          int64
+	Modified      int64
+	Version       protocol.Vector
+	LocalVersion  int64
+	Deleted       bool
+	Invalid       bool
+	Directory     bool
+	Symlink       bool
+	NoPermissions bool
+	Permissions   uint32
+}
+
+func (f FileInfoTruncated) FileSize() int64         { return f.Size }
+func (f FileInfoTruncated) FileName() string        { return f.Name }
+func (f FileInfoTruncated) IsDeleted() bool         { return f.Deleted }
+func (f FileInfoTruncated) IsInvalid() bool         { return f.Invalid }
+func (f FileInfoTruncated) IsDirectory() bool       { return f.Directory }
+func (f FileInfoTruncated) IsSymlink() bool         { return f.Symlink }
+func (f FileInfoTruncated) HasPermissionBits() bool { return !f.NoPermissions }
+
+// toTruncated drops the block list (and anything else WithNeed/WithHave
+// callers shouldn't need for a listing) from f.
+func toTruncated(f protocol.FileInfo) FileInfoTruncated {
+	return FileInfoTruncated{
+		Name:          f.FileName(),
+		Size:          f.FileSize(),
+		Modified:      f.Modified,
+		Version:       f.Version,
+		LocalVersion:  f.LocalVersion,
+		Deleted:       f.IsDeleted(),
+		Invalid:       f.IsInvalid(),
+		Directory:     f.IsDirectory(),
+		Symlink:       f.IsSymlink(),
+		NoPermissions: !f.HasPermissionBits(),
+		Permissions:   f.Permissions,
+	}
+}